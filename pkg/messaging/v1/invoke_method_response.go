@@ -0,0 +1,400 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
+	internalv1pb "github.com/dapr/dapr/pkg/proto/internals/v1"
+)
+
+const (
+	// ProtobufContentType represents the content type for a protobuf-serialized response body
+	// whose content type was not explicitly set by the caller.
+	ProtobufContentType = "application/x-protobuf"
+
+	// pushPromiseHeader is a reserved response header carrying the JSON-encoded PushPromises for
+	// this response. It's how WithHTTP2PushPromises survives the trip across the sidecar
+	// boundary, since InternalInvokeResponse has no dedicated proto field for it; Headers() strips
+	// it back out so callers iterating response headers never see it.
+	pushPromiseHeader = "dapr-http2-push-promise-bin"
+)
+
+// DaprInternalMetadata is the map of headers/trailers exchanged between sidecars.
+type DaprInternalMetadata map[string]*internalv1pb.ListStringValue
+
+// PushPromise describes a resource a caller may advertise alongside an InvokeMethodResponse so
+// it can be pushed to the invoking sidecar before the response body is fully drained.
+type PushPromise struct {
+	Path    string
+	Method  string
+	Headers map[string][]string
+}
+
+// InvokeMethodResponse holds the InternalInvokeResponse proto message and related helper methods.
+type InvokeMethodResponse struct {
+	r      *internalv1pb.InternalInvokeResponse
+	data   io.ReadCloser
+	replay *bytes.Buffer
+
+	pushPromises []PushPromise
+	trailerFunc  func() map[string][]string
+
+	sseResumeID string
+	sseMu       sync.Mutex
+	sseEvents   []SSEEvent
+
+	flowControl *flowControlledReader
+	spillDir    string
+}
+
+// NewInvokeMethodResponse returns a new InvokeMethodResponse with the given status code, message and details.
+func NewInvokeMethodResponse(statusCode int32, statusMessage string, statusDetails []*anypb.Any) *InvokeMethodResponse {
+	return &InvokeMethodResponse{
+		r: &internalv1pb.InternalInvokeResponse{
+			Status: &internalv1pb.Status{
+				Code:    statusCode,
+				Message: statusMessage,
+				Details: statusDetails,
+			},
+			Message: &commonv1pb.InvokeResponse{},
+		},
+	}
+}
+
+// InternalInvokeResponse returns an InvokeMethodResponse wrapping the given internal proto message.
+func InternalInvokeResponse(pb *internalv1pb.InternalInvokeResponse) (*InvokeMethodResponse, error) {
+	imr := &InvokeMethodResponse{r: pb}
+	if pb.Message == nil {
+		imr.r.Message = &commonv1pb.InvokeResponse{}
+	}
+
+	return imr, nil
+}
+
+// WithMessage sets the common InvokeResponse message.
+func (imr *InvokeMethodResponse) WithMessage(pb *commonv1pb.InvokeResponse) *InvokeMethodResponse {
+	imr.r.Message = pb
+	return imr
+}
+
+// WithRawData sets the stream to read the response data from.
+func (imr *InvokeMethodResponse) WithRawData(data io.Reader) *InvokeMethodResponse {
+	if data == nil {
+		return imr
+	}
+	if rc, ok := data.(io.ReadCloser); ok {
+		imr.data = rc
+	} else {
+		imr.data = io.NopCloser(data)
+	}
+	imr.r.Message.Data = nil
+	return imr
+}
+
+// WithRawDataBytes sets the data directly from a byte slice.
+func (imr *InvokeMethodResponse) WithRawDataBytes(data []byte) *InvokeMethodResponse {
+	return imr.WithRawData(bytes.NewReader(data))
+}
+
+// WithRawDataString sets the data directly from a string.
+func (imr *InvokeMethodResponse) WithRawDataString(data string) *InvokeMethodResponse {
+	return imr.WithRawDataBytes([]byte(data))
+}
+
+// WithContentType sets the content type of the response data.
+func (imr *InvokeMethodResponse) WithContentType(contentType string) *InvokeMethodResponse {
+	imr.r.Message.ContentType = contentType
+	return imr
+}
+
+// WithReplay enables or disables the replay buffer so RawData can be consumed more than once.
+func (imr *InvokeMethodResponse) WithReplay(replay bool) *InvokeMethodResponse {
+	if replay {
+		imr.replay = &bytes.Buffer{}
+	} else {
+		imr.replay = nil
+	}
+	return imr
+}
+
+// WithHeaders sets gRPC response headers.
+func (imr *InvokeMethodResponse) WithHeaders(headers map[string][]string) *InvokeMethodResponse {
+	imr.r.Headers = metadataToInternalMetadata(headers)
+	return imr
+}
+
+// WithFastHTTPHeaders populates response headers from a fasthttp.ResponseHeader.
+func (imr *InvokeMethodResponse) WithFastHTTPHeaders(header *fasthttp.ResponseHeader) *InvokeMethodResponse {
+	md := map[string][]string{}
+	header.VisitAll(func(key []byte, value []byte) {
+		md[string(key)] = append(md[string(key)], string(value))
+	})
+	imr.r.Headers = metadataToInternalMetadata(md)
+	return imr
+}
+
+// WithTrailers sets gRPC response trailers.
+func (imr *InvokeMethodResponse) WithTrailers(trailers map[string][]string) *InvokeMethodResponse {
+	imr.r.Trailers = metadataToInternalMetadata(trailers)
+	return imr
+}
+
+// WithHTTP2PushPromises attaches HTTP/2 server-push resources that travel alongside the response
+// across the sidecar boundary, so the invoking side can act on them without an extra round trip.
+// The promises are carried in a reserved response header so they survive being marshaled onto the
+// wire, not just within the process that called WithHTTP2PushPromises.
+func (imr *InvokeMethodResponse) WithHTTP2PushPromises(promises []PushPromise) *InvokeMethodResponse {
+	imr.pushPromises = promises
+	if len(promises) == 0 {
+		return imr
+	}
+
+	encoded := make([]string, len(promises))
+	for i, p := range promises {
+		b, err := json.Marshal(p)
+		if err != nil {
+			continue
+		}
+		encoded[i] = string(b)
+	}
+	if imr.r.Headers == nil {
+		imr.r.Headers = DaprInternalMetadata{}
+	}
+	imr.r.Headers[pushPromiseHeader] = &internalv1pb.ListStringValue{Values: encoded}
+
+	return imr
+}
+
+// PushPromises returns the HTTP/2 server-push resources advertised for this response, if any,
+// decoding them from the wire header if this InvokeMethodResponse was constructed from a received
+// proto message rather than built locally via WithHTTP2PushPromises.
+func (imr *InvokeMethodResponse) PushPromises() []PushPromise {
+	if imr.pushPromises != nil {
+		return imr.pushPromises
+	}
+
+	values := imr.r.GetHeaders()[pushPromiseHeader].GetValues()
+	if len(values) == 0 {
+		return nil
+	}
+
+	promises := make([]PushPromise, 0, len(values))
+	for _, v := range values {
+		var p PushPromise
+		if err := json.Unmarshal([]byte(v), &p); err != nil {
+			continue
+		}
+		promises = append(promises, p)
+	}
+	imr.pushPromises = promises
+
+	return promises
+}
+
+// WithStreamingTrailers registers a trailer source that is only resolved once the response body
+// returned by RawData has been fully drained. This lets callers surface trailers that aren't known
+// until the underlying net/http or gRPC stream reaches its end (e.g. grpc-status, grpc-message),
+// instead of requiring them to be materialized upfront like WithTrailers does.
+func (imr *InvokeMethodResponse) WithStreamingTrailers(trailerFunc func() map[string][]string) *InvokeMethodResponse {
+	imr.trailerFunc = trailerFunc
+	return imr
+}
+
+func (imr *InvokeMethodResponse) resolveStreamingTrailers() {
+	if imr.trailerFunc == nil {
+		return
+	}
+	trailerFunc := imr.trailerFunc
+	imr.trailerFunc = nil
+	if md := trailerFunc(); len(md) > 0 {
+		imr.r.Trailers = metadataToInternalMetadata(md)
+	}
+}
+
+// Status returns the status of the response.
+func (imr *InvokeMethodResponse) Status() *internalv1pb.Status {
+	return imr.r.GetStatus()
+}
+
+// IsHTTPResponse returns true if the status code follows the HTTP response status convention
+// rather than the gRPC status code convention.
+func (imr *InvokeMethodResponse) IsHTTPResponse() bool {
+	return IsHTTPResponse(imr.r.GetStatus().GetCode())
+}
+
+// IsHTTPResponse returns true if the given status code follows the HTTP response status
+// convention rather than the gRPC status code convention.
+func IsHTTPResponse(code int32) bool {
+	return code >= 100 && code <= 599
+}
+
+// Proto returns the internal InternalInvokeResponse proto message without draining the response
+// data stream. Use ProtoWithData if the data needs to be materialized into the message.
+func (imr *InvokeMethodResponse) Proto() *internalv1pb.InternalInvokeResponse {
+	return imr.r
+}
+
+// ProtoWithData returns the internal InternalInvokeResponse proto message with the response data
+// fully read and materialized into the message's Data field. In SSE mode, if Events has retained
+// any decoded events (which it does only with WithReplay(true)), those retained events are
+// re-serialized as newline-delimited SSE frames rather than drained again from the underlying stream.
+func (imr *InvokeMethodResponse) ProtoWithData() (*internalv1pb.InternalInvokeResponse, error) {
+	if imr.r == nil {
+		return nil, nil
+	}
+
+	if imr.ContentType() == SSEContentType && imr.hasSSEEvents() {
+		imr.r.Message.Data = &anypb.Any{Value: imr.serializeSSEEvents()}
+		return imr.r, nil
+	}
+
+	data, err := imr.RawDataFull()
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		imr.r.Message.Data = &anypb.Any{Value: data}
+	}
+
+	return imr.r, nil
+}
+
+// Headers returns the gRPC response headers. The reserved header WithHTTP2PushPromises uses to
+// carry push promises across the wire is not included; use PushPromises to read those back.
+func (imr *InvokeMethodResponse) Headers() DaprInternalMetadata {
+	headers := imr.r.GetHeaders()
+	if _, ok := headers[pushPromiseHeader]; !ok {
+		return headers
+	}
+
+	filtered := make(DaprInternalMetadata, len(headers)-1)
+	for k, v := range headers {
+		if k == pushPromiseHeader {
+			continue
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// Trailers returns the gRPC response trailers. When WithStreamingTrailers was used, the returned
+// value only reflects the finalized trailers once the response data has been fully drained.
+func (imr *InvokeMethodResponse) Trailers() DaprInternalMetadata {
+	return imr.r.GetTrailers()
+}
+
+// Message returns the common InvokeResponse message.
+func (imr *InvokeMethodResponse) Message() *commonv1pb.InvokeResponse {
+	return imr.r.Message
+}
+
+// ContentType returns the content type of the response data.
+func (imr *InvokeMethodResponse) ContentType() string {
+	if ct := imr.r.GetMessage().GetContentType(); ct != "" {
+		return ct
+	}
+	if imr.r.GetMessage().GetData().GetTypeUrl() != "" {
+		return ProtobufContentType
+	}
+	return ""
+}
+
+// RawData returns a reader for the response data. If the data has already been materialized into
+// the message (e.g. via ProtoWithData or by direct assignment), that takes priority over the
+// underlying stream. When a replay buffer is enabled via WithReplay, the stream can be read more
+// than once: already-read bytes are served from the buffer, and any remaining bytes are teed into
+// it as they're read.
+func (imr *InvokeMethodResponse) RawData() io.Reader {
+	if imr.r == nil || imr.r.Message == nil {
+		return nil
+	}
+
+	var r io.Reader
+	switch {
+	case imr.r.Message.Data != nil:
+		r = bytes.NewReader(imr.r.Message.Data.Value)
+	case imr.data == nil:
+		return nil
+	case imr.replay != nil:
+		r = io.MultiReader(bytes.NewReader(imr.replay.Bytes()), io.TeeReader(imr.data, imr.replay))
+	default:
+		r = imr.data
+	}
+
+	if imr.trailerFunc != nil {
+		r = &trailerResolvingReader{Reader: r, resolve: imr.resolveStreamingTrailers}
+	}
+
+	return r
+}
+
+// RawDataFull reads the response data in its entirety and returns it as a byte slice.
+func (imr *InvokeMethodResponse) RawDataFull() ([]byte, error) {
+	r := imr.RawData()
+	if r == nil {
+		return nil, nil
+	}
+	return io.ReadAll(r)
+}
+
+// Close releases the resources held by the response, including its data stream and replay buffer.
+func (imr *InvokeMethodResponse) Close() error {
+	if imr == nil {
+		return nil
+	}
+
+	if imr.data != nil {
+		err := imr.data.Close()
+		imr.data = nil
+		if err != nil {
+			return err
+		}
+	}
+	imr.replay = nil
+
+	return nil
+}
+
+// trailerResolvingReader resolves a pending streaming-trailer source the first time the wrapped
+// reader reports io.EOF, so late-bound trailers survive being teed through a replay buffer.
+type trailerResolvingReader struct {
+	io.Reader
+	resolve func()
+	done    bool
+}
+
+func (r *trailerResolvingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		r.resolve()
+	}
+	return n, err
+}
+
+func metadataToInternalMetadata(md map[string][]string) DaprInternalMetadata {
+	internalMD := make(DaprInternalMetadata, len(md))
+	for k, values := range md {
+		internalMD[k] = &internalv1pb.ListStringValue{Values: values}
+	}
+	return internalMD
+}
@@ -0,0 +1,325 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultSpillOverflow is how much credit beyond the current flow-control window a spilling
+// buffer keeps in memory before writing further bytes to disk.
+const defaultSpillOverflow = 1 << 20 // 1MiB
+
+// FlowControlStats reports the state of a flow-controlled response stream, so operators can
+// observe a consumer that has stopped acknowledging delivered data.
+type FlowControlStats struct {
+	Delivered int
+	Buffered  int
+	Spilled   int
+	LastAckAt time.Time
+}
+
+// WithFlowControl wraps the response's data stream with a credit-based flow-control scheme
+// similar to HTTP/2 and gRPC window updates: reads block once initialWindow bytes have been
+// delivered to the consumer, and unblock as the consumer calls Ack to grant more credit.
+// onWindowUpdate, if non-nil, is called every time Ack grows the window, with the new window
+// size, so callers can propagate credit upstream (e.g. as an HTTP/2 WINDOW_UPDATE frame).
+func (imr *InvokeMethodResponse) WithFlowControl(initialWindow int, onWindowUpdate func(int)) *InvokeMethodResponse {
+	if imr.data == nil {
+		return imr
+	}
+
+	fc := newFlowControlledReader(imr.data, initialWindow, onWindowUpdate)
+	if imr.spillDir != "" {
+		fc.attachSpill(newSpillingBuffer(imr.spillDir, defaultSpillOverflow, fc.currentWindow))
+	}
+	imr.flowControl = fc
+	imr.data = fc
+
+	return imr
+}
+
+// WithSpillDir configures a directory the flow-controlled stream's buffer spills to once it grows
+// past the current window plus its overflow allowance, so a slow consumer of a large response
+// isn't kept fully in memory: only the bounded head is, with the rest spilled to disk and
+// recoverable via FlowControlReplay. It's a no-op unless WithFlowControl is also used.
+func (imr *InvokeMethodResponse) WithSpillDir(dir string) *InvokeMethodResponse {
+	imr.spillDir = dir
+	if imr.flowControl != nil {
+		imr.flowControl.attachSpillIfAbsent(dir)
+	}
+	return imr
+}
+
+// FlowControlReplay returns a reader over everything a flow-controlled stream has delivered so
+// far, including the portion already spilled to disk. It's the flow-control analogue of the
+// in-memory replay buffer enabled by WithReplay, for responses too large to keep fully in memory.
+// It returns a nil reader if WithFlowControl or WithSpillDir wasn't used; callers must check for
+// nil before calling Close, and Close the reader when it's non-nil, since it may hold an open file
+// descriptor onto the spilled data.
+func (imr *InvokeMethodResponse) FlowControlReplay() (io.ReadCloser, error) {
+	if imr.flowControl == nil {
+		return nil, nil
+	}
+	return imr.flowControl.replay()
+}
+
+// Ack grants n additional bytes of credit to the flow-controlled stream, unblocking reads that
+// were waiting on the window. It's a no-op if WithFlowControl wasn't used.
+func (imr *InvokeMethodResponse) Ack(n int) {
+	if imr.flowControl == nil {
+		return
+	}
+	imr.flowControl.ack(n)
+}
+
+// Stats returns the current delivery/buffering state of a flow-controlled stream. It's the zero
+// value if WithFlowControl wasn't used.
+func (imr *InvokeMethodResponse) Stats() FlowControlStats {
+	if imr.flowControl == nil {
+		return FlowControlStats{}
+	}
+	return imr.flowControl.stats()
+}
+
+// flowControlledReader wraps a response's data stream so reads block once the available window
+// is exhausted, unblocking as ack grants more credit. It optionally tees delivered bytes into a
+// spillingBuffer so a slow consumer can't force the whole response to stay in memory.
+type flowControlledReader struct {
+	r io.ReadCloser
+
+	cond      *sync.Cond
+	window    int
+	delivered int
+	lastAckAt time.Time
+	closed    bool
+	onUpdate  func(int)
+
+	spill *spillingBuffer
+}
+
+func newFlowControlledReader(r io.ReadCloser, initialWindow int, onUpdate func(int)) *flowControlledReader {
+	return &flowControlledReader{
+		r:        r,
+		window:   initialWindow,
+		onUpdate: onUpdate,
+		cond:     sync.NewCond(&sync.Mutex{}),
+	}
+}
+
+func (f *flowControlledReader) Read(p []byte) (int, error) {
+	f.cond.L.Lock()
+	for f.window <= 0 && !f.closed {
+		f.cond.Wait()
+	}
+	if f.closed {
+		f.cond.L.Unlock()
+		return 0, io.EOF
+	}
+	if len(p) > f.window {
+		p = p[:f.window]
+	}
+	spill := f.spill
+	f.cond.L.Unlock()
+
+	n, err := f.r.Read(p)
+
+	if n > 0 && spill != nil {
+		if _, spillErr := spill.Write(p[:n]); spillErr != nil && err == nil {
+			err = spillErr
+		}
+	}
+
+	f.cond.L.Lock()
+	f.window -= n
+	f.delivered += n
+	f.cond.L.Unlock()
+
+	return n, err
+}
+
+func (f *flowControlledReader) ack(n int) {
+	f.cond.L.Lock()
+	f.window += n
+	f.lastAckAt = time.Now()
+	window := f.window
+	f.cond.L.Unlock()
+	f.cond.Broadcast()
+
+	if f.onUpdate != nil {
+		f.onUpdate(window)
+	}
+}
+
+func (f *flowControlledReader) attachSpill(spill *spillingBuffer) {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	f.spill = spill
+}
+
+func (f *flowControlledReader) attachSpillIfAbsent(dir string) {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	if f.spill != nil {
+		return
+	}
+	f.spill = newSpillingBuffer(dir, defaultSpillOverflow, f.currentWindow)
+}
+
+// currentWindow returns the live window size, reflecting any credit granted since construction
+// via ack. It's used as the spill buffer's moving threshold rather than a value frozen at the
+// time flow control (or spilling) was configured.
+func (f *flowControlledReader) currentWindow() int {
+	f.cond.L.Lock()
+	defer f.cond.L.Unlock()
+	return f.window
+}
+
+func (f *flowControlledReader) stats() FlowControlStats {
+	f.cond.L.Lock()
+	stats := FlowControlStats{Delivered: f.delivered, LastAckAt: f.lastAckAt}
+	spill := f.spill
+	f.cond.L.Unlock()
+
+	if spill != nil {
+		stats.Buffered, stats.Spilled = spill.stats()
+	}
+	return stats
+}
+
+func (f *flowControlledReader) replay() (io.ReadCloser, error) {
+	f.cond.L.Lock()
+	spill := f.spill
+	f.cond.L.Unlock()
+
+	if spill == nil {
+		return nil, nil
+	}
+	return spill.reader()
+}
+
+func (f *flowControlledReader) Close() error {
+	f.cond.L.Lock()
+	f.closed = true
+	spill := f.spill
+	f.cond.L.Unlock()
+	f.cond.Broadcast()
+
+	err := f.r.Close()
+	if spill != nil {
+		if spillErr := spill.Close(); err == nil {
+			err = spillErr
+		}
+	}
+	return err
+}
+
+// spillingBuffer is a bounded, append-only buffer: it keeps at most window()+overflow bytes in
+// memory, where window is re-read on every write instead of fixed at construction, so the bound
+// moves with the flow-control window as it grows via ack and shrinks as reads deliver bytes. Once
+// that's exceeded, it spills everything after it to a temp file under dir, so the whole response
+// can still be replayed later without holding it all in memory at once.
+type spillingBuffer struct {
+	dir      string
+	overflow int
+	window   func() int
+
+	mu      sync.Mutex
+	mem     bytes.Buffer
+	spill   *os.File
+	spilled int
+}
+
+func newSpillingBuffer(dir string, overflow int, window func() int) *spillingBuffer {
+	return &spillingBuffer{dir: dir, overflow: overflow, window: window}
+}
+
+func (b *spillingBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Once spilling has started, keep appending to the file: writing new data back into mem
+	// whenever it happens to have room left would put it out of order relative to what's on disk.
+	if b.spill == nil {
+		if maxMemory := b.window() + b.overflow; b.mem.Len()+len(p) <= maxMemory {
+			return b.mem.Write(p)
+		}
+
+		f, err := os.CreateTemp(b.dir, "dapr-invoke-response-*.spill")
+		if err != nil {
+			return 0, err
+		}
+		b.spill = f
+	}
+	if _, err := b.spill.Write(p); err != nil {
+		return 0, err
+	}
+	b.spilled += len(p)
+	return len(p), nil
+}
+
+func (b *spillingBuffer) stats() (buffered, spilled int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.mem.Len(), b.spilled
+}
+
+// reader returns a reader over everything written so far, in order: the in-memory head followed
+// by whatever has since been spilled to disk. The caller must Close it to release the file
+// descriptor opened onto the spilled portion, if any.
+func (b *spillingBuffer) reader() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	memCopy := append([]byte(nil), b.mem.Bytes()...)
+	if b.spill == nil {
+		return io.NopCloser(bytes.NewReader(memCopy)), nil
+	}
+
+	f, err := os.Open(b.spill.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &spillReplayReader{Reader: io.MultiReader(bytes.NewReader(memCopy), f), f: f}, nil
+}
+
+// spillReplayReader pairs the combined mem+spill reader returned by spillingBuffer.reader with the
+// open file descriptor backing its tail, so the caller has something to Close.
+type spillReplayReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (r *spillReplayReader) Close() error {
+	return r.f.Close()
+}
+
+func (b *spillingBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.spill == nil {
+		return nil
+	}
+	name := b.spill.Name()
+	err := b.spill.Close()
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseFlowControl(t *testing.T) {
+	t.Run("read blocks until acked", func(t *testing.T) {
+		const message = "nel blu dipinto di blu"
+
+		var windowUpdates []int
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(message).
+			WithFlowControl(5, func(w int) { windowUpdates = append(windowUpdates, w) })
+		defer resp.Close()
+
+		r := resp.RawData()
+
+		first := make([]byte, len(message))
+		n, err := r.Read(first)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Equal(t, message[:5], string(first[:n]))
+
+		done := make(chan struct{})
+		var rest []byte
+		go func() {
+			defer close(done)
+			rest, _ = io.ReadAll(r)
+		}()
+
+		select {
+		case <-done:
+			t.Fatal("read completed before the window was acked")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		resp.Ack(len(message))
+		<-done
+
+		assert.Equal(t, message[5:], string(rest))
+		assert.Equal(t, []int{len(message)}, windowUpdates)
+	})
+
+	t.Run("stats report delivered bytes and last ack time", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString("test").
+			WithFlowControl(5, nil)
+		defer resp.Close()
+
+		_, err := io.ReadAll(resp.RawData())
+		assert.NoError(t, err)
+
+		before := time.Now()
+		resp.Ack(10)
+		stats := resp.Stats()
+
+		assert.Equal(t, 4, stats.Delivered)
+		assert.False(t, stats.LastAckAt.Before(before))
+	})
+
+	t.Run("spills beyond the window to disk", func(t *testing.T) {
+		const message = "0123456789"
+
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(message).
+			WithSpillDir(t.TempDir()).
+			WithFlowControl(len(message)+1, nil)
+		defer resp.Close()
+
+		resp.flowControl.spill.window = func() int { return 0 }
+		resp.flowControl.spill.overflow = 2
+
+		data, err := io.ReadAll(resp.RawData())
+		require.NoError(t, err)
+		assert.Equal(t, message, string(data))
+
+		stats := resp.Stats()
+		assert.Equal(t, len(message)-stats.Buffered, stats.Spilled)
+		assert.Positive(t, stats.Spilled)
+
+		replay, err := resp.FlowControlReplay()
+		require.NoError(t, err)
+		defer replay.Close()
+		replayed, err := io.ReadAll(replay)
+		require.NoError(t, err)
+		assert.Equal(t, message, string(replayed))
+	})
+
+	t.Run("spill threshold tracks window growth from Ack", func(t *testing.T) {
+		const message = "0123456789"
+
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(message).
+			WithSpillDir(t.TempDir()).
+			WithFlowControl(2, nil)
+		defer resp.Close()
+
+		resp.flowControl.spill.overflow = 0
+
+		r := resp.RawData()
+		first := make([]byte, 2)
+		_, err := io.ReadFull(r, first)
+		require.NoError(t, err)
+
+		// Grant enough additional credit that the live window, not the value captured when flow
+		// control was configured, is what bounds how much the spill buffer keeps in memory.
+		resp.Ack(len(message))
+
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, message[2:], string(rest))
+
+		stats := resp.Stats()
+		assert.Zero(t, stats.Spilled, "growth in the window should have kept the rest in memory instead of spilling it")
+	})
+
+	t.Run("no flow control is a no-op", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).WithRawDataString("test")
+		defer resp.Close()
+
+		resp.Ack(10)
+		assert.Equal(t, FlowControlStats{}, resp.Stats())
+	})
+
+	t.Run("FlowControlReplay is nil without a spill dir", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString("test").
+			WithFlowControl(5, nil)
+		defer resp.Close()
+
+		replay, err := resp.FlowControlReplay()
+		require.NoError(t, err)
+		assert.Nil(t, replay)
+	})
+}
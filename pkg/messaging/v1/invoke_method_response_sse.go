@@ -0,0 +1,241 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+)
+
+const (
+	// SSEContentType is the content type that switches InvokeMethodResponse into per-event
+	// Server-Sent Events mode: RawData is decoded/encoded field-by-field instead of treated as an
+	// opaque byte buffer.
+	SSEContentType = "text/event-stream"
+
+	// sseReplayRingSize bounds how many decoded events are retained for replay/serialization so a
+	// long-lived stream doesn't grow the buffer unboundedly.
+	sseReplayRingSize = 256
+)
+
+// SSEEvent is a single decoded Server-Sent Events frame.
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  string
+	Retry string
+
+	// Raw holds the frame's lines as decoded, including the terminating blank line if there was
+	// one, so the frame can be re-emitted without re-encoding its fields. Every line in Raw ends
+	// with "\n": a "\r\n" or "\r" line ending in the source stream is not preserved, nor is a
+	// missing newline after the last line of the stream.
+	Raw []byte
+}
+
+// WithSSEResumeID records the id of the last event the caller has already delivered, so a retried
+// invocation resumes the stream from the event that follows it instead of replaying from the start.
+func (imr *InvokeMethodResponse) WithSSEResumeID(id string) *InvokeMethodResponse {
+	imr.sseResumeID = id
+	return imr
+}
+
+// Events decodes RawData as a sequence of SSE frames and returns them on a channel, closed once
+// the stream is exhausted. It's only meaningful when ContentType is SSEContentType. If
+// WithSSEResumeID was used, events up to and including that id are skipped instead of emitted; if
+// the id is never observed in the stream (e.g. it aged out of the replay ring on the producer
+// side, or the producer restarted), Events falls back to emitting the whole stream from the start
+// rather than silently dropping it. When WithReplay(true) has been set, each decoded event (including
+// those skipped while resuming) is also retained in a bounded ring so it can be replayed later instead of the opaque byte buffer that
+// WithReplay otherwise drives (see serializeSSEEvents). Calling Events again on a retried
+// invocation re-decodes the replayed prefix RawData serves from the replay buffer, but only events
+// decoded from bytes freshly read off the live stream are buffered again, so the ring isn't
+// duplicated with each retry.
+//
+// The caller must drain the channel to completion or cancel ctx; otherwise the decode goroutine
+// leaks, blocked trying to send the next event.
+func (imr *InvokeMethodResponse) Events(ctx context.Context) <-chan SSEEvent {
+	out := make(chan SSEEvent)
+
+	var replayedLen int
+	if imr.replay != nil {
+		replayedLen = imr.replay.Len()
+	}
+
+	r := imr.RawData()
+	if r == nil {
+		close(out)
+		return out
+	}
+
+	go func() {
+		defer close(out)
+
+		resumeID := imr.sseResumeID
+		resumePending := resumeID != ""
+		var pending []SSEEvent
+
+		emit := func(ev SSEEvent) bool {
+			// Check first so a cancelled ctx isn't raced against a waiting receiver in the select.
+			if ctx.Err() != nil {
+				return false
+			}
+			select {
+			case out <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		dec := newSSEDecoder(r)
+		for {
+			ev, ok := dec.Next()
+			if !ok {
+				break
+			}
+
+			// Only buffer events decoded from bytes freshly read off the live stream; the
+			// replayed prefix was already buffered the call that first saw it.
+			if dec.Consumed() > replayedLen {
+				imr.bufferSSEEvent(ev)
+			}
+
+			if resumePending {
+				pending = append(pending, ev)
+				if ev.ID == resumeID {
+					resumePending = false
+					pending = nil
+				}
+				continue
+			}
+
+			if !emit(ev) {
+				return
+			}
+		}
+
+		// resumeID was never observed: replay from the start instead of delivering nothing.
+		for _, ev := range pending {
+			if !emit(ev) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (imr *InvokeMethodResponse) bufferSSEEvent(ev SSEEvent) {
+	if imr.replay == nil {
+		return
+	}
+
+	imr.sseMu.Lock()
+	defer imr.sseMu.Unlock()
+
+	imr.sseEvents = append(imr.sseEvents, ev)
+	if len(imr.sseEvents) > sseReplayRingSize {
+		imr.sseEvents = imr.sseEvents[len(imr.sseEvents)-sseReplayRingSize:]
+	}
+}
+
+func (imr *InvokeMethodResponse) hasSSEEvents() bool {
+	imr.sseMu.Lock()
+	defer imr.sseMu.Unlock()
+	return len(imr.sseEvents) > 0
+}
+
+func (imr *InvokeMethodResponse) serializeSSEEvents() []byte {
+	imr.sseMu.Lock()
+	defer imr.sseMu.Unlock()
+
+	var buf bytes.Buffer
+	for _, ev := range imr.sseEvents {
+		buf.Write(ev.Raw)
+	}
+	return buf.Bytes()
+}
+
+// sseDecoder decodes a stream of "event:"/"data:"/"id:"/"retry:" fields into SSEEvent frames,
+// following the W3C EventSource framing: fields are newline-separated, a frame ends at the first
+// blank line, and lines before any field in a frame (or entirely blank lines between frames) are
+// skipped.
+type sseDecoder struct {
+	scanner  *bufio.Scanner
+	pending  bytes.Buffer
+	consumed int
+}
+
+func newSSEDecoder(r io.Reader) *sseDecoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 4096), 1<<20)
+	return &sseDecoder{scanner: s}
+}
+
+// Consumed returns the length of the stream decoded so far, up to and including the frame most
+// recently returned by Next, counting each scanned line plus one byte for its terminator. Bytes the
+// scanner has read ahead of that point are not counted.
+func (d *sseDecoder) Consumed() int {
+	return d.consumed
+}
+
+// Next returns the next decoded frame, or ok=false once the underlying stream is exhausted.
+func (d *sseDecoder) Next() (SSEEvent, bool) {
+	var ev SSEEvent
+	var data []string
+	sawField := false
+
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		d.consumed += len(line) + 1
+		d.pending.WriteString(line)
+		d.pending.WriteByte('\n')
+
+		if line == "" {
+			if !sawField {
+				d.pending.Reset()
+				continue
+			}
+			ev.Data = strings.Join(data, "\n")
+			ev.Raw = append([]byte(nil), d.pending.Bytes()...)
+			d.pending.Reset()
+			return ev, true
+		}
+
+		sawField = true
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			ev.ID = value
+		case "event":
+			ev.Event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			ev.Retry = value
+		}
+	}
+
+	if sawField {
+		ev.Data = strings.Join(data, "\n")
+		ev.Raw = append([]byte(nil), d.pending.Bytes()...)
+		return ev, true
+	}
+
+	return SSEEvent{}, false
+}
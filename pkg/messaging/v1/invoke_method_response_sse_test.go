@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Dapr Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseSSEEvents(t *testing.T) {
+	const stream = "id: 1\nevent: progress\ndata: 10%\n\n" +
+		"id: 2\nevent: progress\ndata: line one\ndata: line two\n\n"
+
+	t.Run("decodes each frame", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType)
+		defer resp.Close()
+
+		var events []SSEEvent
+		for ev := range resp.Events(context.Background()) {
+			events = append(events, ev)
+		}
+
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "1", events[0].ID)
+			assert.Equal(t, "progress", events[0].Event)
+			assert.Equal(t, "10%", events[0].Data)
+
+			assert.Equal(t, "2", events[1].ID)
+			assert.Equal(t, "line one\nline two", events[1].Data)
+		}
+	})
+
+	t.Run("resumes after the given id", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType).
+			WithSSEResumeID("1")
+		defer resp.Close()
+
+		var events []SSEEvent
+		for ev := range resp.Events(context.Background()) {
+			events = append(events, ev)
+		}
+
+		if assert.Len(t, events, 1) {
+			assert.Equal(t, "2", events[0].ID)
+		}
+	})
+
+	t.Run("falls back to replaying from the start when the resume id is never observed", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType).
+			WithSSEResumeID("stale-id-not-in-stream")
+		defer resp.Close()
+
+		var events []SSEEvent
+		for ev := range resp.Events(context.Background()) {
+			events = append(events, ev)
+		}
+
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "1", events[0].ID)
+			assert.Equal(t, "2", events[1].ID)
+		}
+	})
+
+	t.Run("cancelling the context stops the decode goroutine instead of leaking it", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType)
+		defer resp.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := resp.Events(ctx)
+
+		first, ok := <-events
+		assert.True(t, ok)
+		assert.Equal(t, "1", first.ID)
+
+		cancel()
+
+		select {
+		case _, ok := <-events:
+			assert.False(t, ok, "channel should close once the context is cancelled")
+		case <-time.After(time.Second):
+			t.Fatal("decode goroutine did not observe context cancellation")
+		}
+	})
+
+	t.Run("ProtoWithData re-serializes drained events verbatim when replay is enabled", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType).
+			WithReplay(true)
+		defer resp.Close()
+
+		for range resp.Events(context.Background()) {
+		}
+
+		pb, err := resp.ProtoWithData()
+		assert.NoError(t, err)
+		assert.Equal(t, stream, string(pb.Message.Data.Value))
+	})
+
+	t.Run("without WithReplay, events aren't retained for re-serialization", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType)
+		defer resp.Close()
+
+		for range resp.Events(context.Background()) {
+		}
+
+		assert.False(t, resp.hasSSEEvents())
+
+		pb, err := resp.ProtoWithData()
+		assert.NoError(t, err)
+		assert.Empty(t, pb.Message.Data.Value)
+	})
+
+	t.Run("calling Events again on a retried invocation doesn't duplicate already-buffered events", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString(stream).
+			WithContentType(SSEContentType).
+			WithReplay(true)
+		defer resp.Close()
+
+		for range resp.Events(context.Background()) {
+		}
+		for range resp.Events(context.Background()) {
+		}
+
+		pb, err := resp.ProtoWithData()
+		assert.NoError(t, err)
+		assert.Equal(t, stream, string(pb.Message.Data.Value))
+	})
+}
@@ -603,3 +603,65 @@ func TestResponseReplayable(t *testing.T) {
 		})
 	})
 }
+
+func TestResponsePushPromises(t *testing.T) {
+	promises := []PushPromise{
+		{Path: "/styles.css", Method: "GET"},
+		{Path: "/app.js", Method: "GET", Headers: map[string][]string{"Accept-Encoding": {"gzip"}}},
+	}
+
+	resp := NewInvokeMethodResponse(0, "OK", nil).
+		WithHTTP2PushPromises(promises)
+	defer resp.Close()
+
+	assert.Equal(t, promises, resp.PushPromises())
+
+	t.Run("survives a round trip across the wire", func(t *testing.T) {
+		received, err := InternalInvokeResponse(resp.Proto())
+		require.NoError(t, err)
+
+		assert.Equal(t, promises, received.PushPromises())
+	})
+
+	t.Run("is not exposed through Headers", func(t *testing.T) {
+		_, ok := resp.Headers()[pushPromiseHeader]
+		assert.False(t, ok)
+	})
+}
+
+func TestResponseStreamingTrailers(t *testing.T) {
+	t.Run("resolved after data is fully drained", func(t *testing.T) {
+		var resolved bool
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString("test").
+			WithStreamingTrailers(func() map[string][]string {
+				resolved = true
+				return map[string][]string{"grpc-status": {"0"}}
+			})
+		defer resp.Close()
+
+		assert.Empty(t, resp.Trailers())
+		assert.False(t, resolved)
+
+		bData, err := io.ReadAll(resp.RawData())
+		assert.NoError(t, err)
+		assert.Equal(t, "test", string(bData))
+
+		assert.True(t, resolved)
+		assert.Equal(t, "0", resp.Trailers()["grpc-status"].GetValues()[0])
+	})
+
+	t.Run("resolved via ProtoWithData", func(t *testing.T) {
+		resp := NewInvokeMethodResponse(0, "OK", nil).
+			WithRawDataString("test").
+			WithStreamingTrailers(func() map[string][]string {
+				return map[string][]string{"grpc-status": {"0"}}
+			})
+		defer resp.Close()
+
+		pb, err := resp.ProtoWithData()
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("test"), pb.Message.Data.Value)
+		assert.Equal(t, "0", resp.Trailers()["grpc-status"].GetValues()[0])
+	})
+}